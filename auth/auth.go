@@ -0,0 +1,39 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// Scheme authenticates outgoing requests and reacts to a 401 challenge
+// from the server so it can refresh its credentials before a retry.
+type Scheme interface {
+	Name() string
+	Authorize(ctx context.Context, req *http.Request) error
+	HandleChallenge(ctx context.Context, challenge Challenge) error
+}
+
+// Factory builds a Scheme from the arguments following its directive
+// name in the Caddyfile, e.g. "auth bearer <token>" calls the "bearer"
+// factory with []string{"<token>"}.
+type Factory func(args []string) (Scheme, error)
+
+var factories = map[string]Factory{}
+
+// RegisterFactory adds a named scheme factory to the registry so third
+// parties can plug in a custom `auth <name> ...` directive from an
+// init() func.
+func RegisterFactory(name string, f Factory) {
+	factories[name] = f
+}
+
+func NewScheme(name string, args []string) (Scheme, error) {
+	f, ok := factories[name]
+
+	if !ok {
+		return nil, fmt.Errorf("rest storage: unknown auth scheme %q", name)
+	}
+
+	return f(args)
+}
@@ -0,0 +1,40 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// BearerScheme authorizes requests with a static bearer token, matching
+// the module's behavior before credentials moved out of the request
+// body and into the Authorization header.
+type BearerScheme struct {
+	Token string
+}
+
+func NewBearerScheme(args []string) (Scheme, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("auth bearer: expected a single token argument, got %d", len(args))
+	}
+
+	return &BearerScheme{Token: args[0]}, nil
+}
+
+func (b *BearerScheme) Name() string {
+	return "Bearer"
+}
+
+func (b *BearerScheme) Authorize(ctx context.Context, req *http.Request) error {
+	req.Header.Set("Authorization", "Bearer "+b.Token)
+
+	return nil
+}
+
+func (b *BearerScheme) HandleChallenge(ctx context.Context, challenge Challenge) error {
+	return nil
+}
+
+func init() {
+	RegisterFactory("bearer", NewBearerScheme)
+}
@@ -0,0 +1,86 @@
+package auth
+
+import "strings"
+
+// Challenge is a parsed WWW-Authenticate challenge: a scheme name plus
+// its key=value parameters (RFC 7235 section 2.1).
+type Challenge struct {
+	Scheme string
+	Params map[string]string
+}
+
+func ParseChallenge(header string) (Challenge, bool) {
+	header = strings.TrimSpace(header)
+
+	if header == "" {
+		return Challenge{}, false
+	}
+
+	sp := strings.IndexByte(header, ' ')
+
+	if sp < 0 {
+		return Challenge{Scheme: header, Params: map[string]string{}}, true
+	}
+
+	scheme := header[:sp]
+	rest := strings.TrimSpace(header[sp+1:])
+
+	return Challenge{Scheme: scheme, Params: parseParams(rest)}, true
+}
+
+func parseParams(s string) map[string]string {
+	params := map[string]string{}
+
+	for len(s) > 0 {
+		s = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(s), ","))
+
+		eq := strings.IndexByte(s, '=')
+
+		if eq < 0 {
+			break
+		}
+
+		key := strings.TrimSpace(s[:eq])
+		s = s[eq+1:]
+
+		var value string
+
+		if strings.HasPrefix(s, "\"") {
+			value, s = parseQuoted(s)
+		} else {
+			comma := strings.IndexByte(s, ',')
+
+			if comma < 0 {
+				value, s = s, ""
+			} else {
+				value, s = s[:comma], s[comma:]
+			}
+		}
+
+		params[key] = strings.TrimSpace(value)
+	}
+
+	return params
+}
+
+func parseQuoted(s string) (string, string) {
+	s = s[1:]
+
+	var b strings.Builder
+
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if i+1 < len(s) {
+				b.WriteByte(s[i+1])
+				i++
+			}
+		case '"':
+			return b.String(), s[i+1:]
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+
+	return b.String(), ""
+}
@@ -0,0 +1,82 @@
+// Package metrics exposes Prometheus collectors describing the REST
+// storage backend's RPC traffic: counts, latency, in-flight requests,
+// and time spent contending for locks.
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "caddy_rest_storage"
+
+// Collector holds the process-wide Prometheus collectors. All methods
+// are safe for concurrent use.
+type Collector struct {
+	requestsTotal   *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	lockWait        prometheus.Histogram
+	inFlight        *prometheus.GaugeVec
+}
+
+var (
+	once   sync.Once
+	shared *Collector
+)
+
+// Shared returns the process-wide collector, registering its metrics
+// with the default Prometheus registry the first time it's called.
+func Shared() *Collector {
+	once.Do(func() {
+		shared = &Collector{
+			requestsTotal: promauto.NewCounterVec(prometheus.CounterOpts{
+				Namespace: namespace,
+				Name:      "requests_total",
+				Help:      "Count of REST storage RPCs by verb and outcome status.",
+			}, []string{"verb", "status"}),
+			requestDuration: promauto.NewHistogramVec(prometheus.HistogramOpts{
+				Namespace: namespace,
+				Name:      "request_duration_seconds",
+				Help:      "Latency of REST storage RPCs by verb.",
+			}, []string{"verb"}),
+			lockWait: promauto.NewHistogram(prometheus.HistogramOpts{
+				Namespace: namespace,
+				Name:      "lock_wait_seconds",
+				Help:      "Total time spent in the Lock retry loop waiting to acquire a lock.",
+			}),
+			inFlight: promauto.NewGaugeVec(prometheus.GaugeOpts{
+				Namespace: namespace,
+				Name:      "in_flight",
+				Help:      "REST storage RPCs currently in flight, by verb.",
+			}, []string{"verb"}),
+		}
+	})
+
+	return shared
+}
+
+// Observe records the outcome of a single RPC against requests_total
+// and request_duration_seconds.
+func (c *Collector) Observe(verb, status string, duration time.Duration) {
+	c.requestsTotal.WithLabelValues(verb, status).Inc()
+	c.requestDuration.WithLabelValues(verb).Observe(duration.Seconds())
+}
+
+// ObserveLockWait records the total time a Lock call spent in its
+// retry loop, including all contended attempts.
+func (c *Collector) ObserveLockWait(duration time.Duration) {
+	c.lockWait.Observe(duration.Seconds())
+}
+
+// InFlight increments the in_flight gauge for verb and returns a func
+// that decrements it; call the returned func when the RPC completes.
+func (c *Collector) InFlight(verb string) func() {
+	c.inFlight.WithLabelValues(verb).Inc()
+
+	return func() {
+		c.inFlight.WithLabelValues(verb).Dec()
+	}
+}
@@ -3,45 +3,243 @@ package rest
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io/fs"
+	"io"
 	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+
 	"github.com/caddyserver/caddy/v2"
 	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
 	"github.com/caddyserver/certmagic"
+	"github.com/fluidnode/caddy_rest_storage/auth"
+	"github.com/fluidnode/caddy_rest_storage/metrics"
+	"github.com/fluidnode/caddy_rest_storage/resterr"
+	"github.com/fluidnode/caddy_rest_storage/retry"
+	"github.com/fluidnode/caddy_rest_storage/tracing"
+)
+
+const (
+	protocolJSON   = "json"
+	protocolBinary = "binary"
 )
 
 type RestStorage struct {
 	Endpoint string `json:"endpoint"`
-	Token    string `json:"token"`
-	client   *http.Client
+
+	AuthScheme string   `json:"auth_scheme,omitempty"`
+	AuthArgs   []string `json:"auth_args,omitempty"`
+
+	LockInitialDelay caddy.Duration `json:"lock_initial_delay,omitempty"`
+	LockMaxDelay     caddy.Duration `json:"lock_max_delay,omitempty"`
+	LockTimeout      caddy.Duration `json:"lock_timeout,omitempty"`
+	LockJitter       *bool          `json:"lock_jitter,omitempty"`
+
+	// Protocol pins the wire format ("json" or "binary"); left empty it
+	// is negotiated against the server on first use.
+	Protocol string `json:"protocol,omitempty"`
+
+	Metrics *bool `json:"metrics,omitempty"`
+	Tracing *bool `json:"tracing,omitempty"`
+
+	// Timeout bounds the entire lifetime of a single HTTP round trip
+	// (dial, TLS handshake, request, response headers and body). Left
+	// unset, requests have no client-side deadline beyond ctx.
+	Timeout caddy.Duration `json:"timeout,omitempty"`
+
+	TLS *TLSConfig `json:"tls,omitempty"`
+
+	ProxyURL string `json:"proxy,omitempty"`
+
+	MaxIdleConns          int            `json:"max_idle_conns,omitempty"`
+	MaxConnsPerHost       int            `json:"max_conns_per_host,omitempty"`
+	IdleConnTimeout       caddy.Duration `json:"idle_conn_timeout,omitempty"`
+	ResponseHeaderTimeout caddy.Duration `json:"response_header_timeout,omitempty"`
+
+	// HTTP2 toggles HTTP/2 support on the transport; it defaults to on.
+	HTTP2 *bool `json:"http2,omitempty"`
+
+	client *http.Client
+	logger *zap.Logger
+
+	authOnce sync.Once
+	auth     auth.Scheme
+	authErr  error
+
+	protocolOnce       sync.Once
+	negotiatedProtocol string
+	protocolErr        error
+}
+
+// TLSConfig configures the TLS transport used to reach Endpoint,
+// including mutual TLS via a client certificate.
+type TLSConfig struct {
+	// CA is a PEM file appended to the system cert pool when verifying
+	// the server's certificate.
+	CA string `json:"ca,omitempty"`
+
+	// Cert and Key are a PEM certificate and private key presented to
+	// the server for mutual TLS. Both must be set together.
+	Cert string `json:"cert,omitempty"`
+	Key  string `json:"key,omitempty"`
+
+	InsecureSkipVerify bool `json:"insecure_skip_verify,omitempty"`
+
+	ServerName string `json:"server_name,omitempty"`
+
+	// MinVersion is "tls1.2" (the default) or "tls1.3".
+	MinVersion string `json:"min_version,omitempty"`
+}
+
+func (t *TLSConfig) build() (*tls.Config, error) {
+	cfg := &tls.Config{
+		InsecureSkipVerify: t.InsecureSkipVerify,
+		ServerName:         t.ServerName,
+	}
+
+	switch t.MinVersion {
+	case "", "tls1.2":
+		cfg.MinVersion = tls.VersionTLS12
+	case "tls1.3":
+		cfg.MinVersion = tls.VersionTLS13
+	default:
+		return nil, fmt.Errorf("rest storage: unknown tls min_version %q", t.MinVersion)
+	}
+
+	if t.CA != "" {
+		pool, err := x509.SystemCertPool()
+
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+
+		ca, err := readFile(t.CA)
+
+		if err != nil {
+			return nil, fmt.Errorf("rest storage: reading tls ca: %w", err)
+		}
+
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("rest storage: no certificates found in %s", t.CA)
+		}
+
+		cfg.RootCAs = pool
+	}
+
+	if t.Cert != "" || t.Key != "" {
+		if t.Cert == "" || t.Key == "" {
+			return nil, errors.New("rest storage: tls cert and key must both be set")
+		}
+
+		certPEM, err := readFile(t.Cert)
+
+		if err != nil {
+			return nil, fmt.Errorf("rest storage: reading tls cert: %w", err)
+		}
+
+		keyPEM, err := readFile(t.Key)
+
+		if err != nil {
+			return nil, fmt.Errorf("rest storage: reading tls key: %w", err)
+		}
+
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+
+		if err != nil {
+			return nil, fmt.Errorf("rest storage: parsing tls cert/key: %w", err)
+		}
+
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return cfg, nil
+}
+
+// readFile reads a configuration file referenced from the Caddyfile.
+// It stands in for Caddy's filesystem abstraction, which the version of
+// caddy this module builds against does not yet expose.
+func readFile(path string) ([]byte, error) {
+	return os.ReadFile(path)
 }
 
 func init() {
-	caddy.RegisterModule(RestStorage{
+	caddy.RegisterModule(&RestStorage{
 		client: &http.Client{},
 	})
 }
 
-func (RestStorage) CaddyModule() caddy.ModuleInfo {
+func (r *RestStorage) CaddyModule() caddy.ModuleInfo {
 	return caddy.ModuleInfo{
 		ID:  "caddy.storage.rest",
 		New: func() caddy.Module { return new(RestStorage) },
 	}
 }
 
-func (r RestStorage) Validate() error {
+func (r *RestStorage) Provision(ctx caddy.Context) error {
+	r.logger = ctx.Logger()
+
+	client, err := r.buildClient()
+
+	if err != nil {
+		return err
+	}
+
+	r.client = client
+
+	return nil
+}
+
+// Cleanup closes idle connections held by the module's HTTP client on
+// shutdown.
+func (r *RestStorage) Cleanup() error {
+	if r.client != nil {
+		r.client.CloseIdleConnections()
+	}
+
+	return nil
+}
+
+func (r *RestStorage) Validate() error {
 	if r.Endpoint == "" {
 		return errors.New("endpoint must be specified")
 	}
 
-	if r.Token == "" {
-		return errors.New("token must be specified")
+	if r.AuthScheme == "" {
+		return errors.New("auth must be specified")
+	}
+
+	if _, err := auth.NewScheme(r.AuthScheme, r.AuthArgs); err != nil {
+		return err
+	}
+
+	switch r.Protocol {
+	case "", protocolJSON, protocolBinary:
+	default:
+		return fmt.Errorf("rest storage: unknown protocol %q", r.Protocol)
+	}
+
+	if r.TLS != nil {
+		switch r.TLS.MinVersion {
+		case "", "tls1.2", "tls1.3":
+		default:
+			return fmt.Errorf("rest storage: unknown tls min_version %q", r.TLS.MinVersion)
+		}
+
+		if (r.TLS.Cert == "") != (r.TLS.Key == "") {
+			return errors.New("rest storage: tls cert and key must both be set")
+		}
 	}
 
 	return nil
@@ -49,19 +247,213 @@ func (r RestStorage) Validate() error {
 
 func (r *RestStorage) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 	for d.Next() {
-		var value string
-
 		key := d.Val()
 
-		if !d.Args(&value) {
-			continue
-		}
-
 		switch key {
 		case "endpoint":
-			r.Endpoint = value
-		case "token":
-			r.Token = value
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+
+			r.Endpoint = d.Val()
+		case "auth":
+			args := d.RemainingArgs()
+
+			if len(args) < 1 {
+				return d.ArgErr()
+			}
+
+			r.AuthScheme = args[0]
+			r.AuthArgs = args[1:]
+		case "lock_initial_delay":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+
+			dur, err := caddy.ParseDuration(d.Val())
+
+			if err != nil {
+				return d.Errf("invalid lock_initial_delay: %v", err)
+			}
+
+			r.LockInitialDelay = caddy.Duration(dur)
+		case "lock_max_delay":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+
+			dur, err := caddy.ParseDuration(d.Val())
+
+			if err != nil {
+				return d.Errf("invalid lock_max_delay: %v", err)
+			}
+
+			r.LockMaxDelay = caddy.Duration(dur)
+		case "lock_timeout":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+
+			dur, err := caddy.ParseDuration(d.Val())
+
+			if err != nil {
+				return d.Errf("invalid lock_timeout: %v", err)
+			}
+
+			r.LockTimeout = caddy.Duration(dur)
+		case "lock_jitter":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+
+			jitter := d.Val() == "on"
+			r.LockJitter = &jitter
+		case "protocol":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+
+			r.Protocol = d.Val()
+		case "metrics":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+
+			metricsOn := d.Val() == "on"
+			r.Metrics = &metricsOn
+		case "tracing":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+
+			tracingOn := d.Val() == "on"
+			r.Tracing = &tracingOn
+		case "timeout":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+
+			dur, err := caddy.ParseDuration(d.Val())
+
+			if err != nil {
+				return d.Errf("invalid timeout: %v", err)
+			}
+
+			r.Timeout = caddy.Duration(dur)
+		case "tls":
+			if r.TLS == nil {
+				r.TLS = &TLSConfig{}
+			}
+
+			for nesting := d.Nesting(); d.NextBlock(nesting); {
+				switch d.Val() {
+				case "ca":
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+
+					r.TLS.CA = d.Val()
+				case "cert":
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+
+					r.TLS.Cert = d.Val()
+				case "key":
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+
+					r.TLS.Key = d.Val()
+				case "insecure_skip_verify":
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+
+					skip, err := strconv.ParseBool(d.Val())
+
+					if err != nil {
+						return d.Errf("invalid insecure_skip_verify: %v", err)
+					}
+
+					r.TLS.InsecureSkipVerify = skip
+				case "server_name":
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+
+					r.TLS.ServerName = d.Val()
+				case "min_version":
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+
+					r.TLS.MinVersion = d.Val()
+				default:
+					return d.ArgErr()
+				}
+			}
+		case "proxy":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+
+			r.ProxyURL = d.Val()
+		case "max_idle_conns":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+
+			n, err := strconv.Atoi(d.Val())
+
+			if err != nil {
+				return d.Errf("invalid max_idle_conns: %v", err)
+			}
+
+			r.MaxIdleConns = n
+		case "max_conns_per_host":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+
+			n, err := strconv.Atoi(d.Val())
+
+			if err != nil {
+				return d.Errf("invalid max_conns_per_host: %v", err)
+			}
+
+			r.MaxConnsPerHost = n
+		case "idle_conn_timeout":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+
+			dur, err := caddy.ParseDuration(d.Val())
+
+			if err != nil {
+				return d.Errf("invalid idle_conn_timeout: %v", err)
+			}
+
+			r.IdleConnTimeout = caddy.Duration(dur)
+		case "response_header_timeout":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+
+			dur, err := caddy.ParseDuration(d.Val())
+
+			if err != nil {
+				return d.Errf("invalid response_header_timeout: %v", err)
+			}
+
+			r.ResponseHeaderTimeout = caddy.Duration(dur)
+		case "http2":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+
+			http2On := d.Val() == "on"
+			r.HTTP2 = &http2On
 		}
 	}
 
@@ -72,70 +464,433 @@ func (r *RestStorage) CertMagicStorage() (certmagic.Storage, error) {
 	return r, nil
 }
 
-type LockRequest struct {
-	Key   string `json:"key"`
-	Token string `json:"token"`
+// scheme lazily builds the configured auth.Scheme the first time it's
+// needed and caches it for the life of r. certmagic calls Store/Load/
+// Delete/Exists/List/Stat concurrently, so the build is guarded by a
+// sync.Once rather than a plain nil check.
+func (r *RestStorage) scheme() (auth.Scheme, error) {
+	r.authOnce.Do(func() {
+		r.auth, r.authErr = auth.NewScheme(r.AuthScheme, r.AuthArgs)
+	})
+
+	return r.auth, r.authErr
 }
 
-func (r *RestStorage) Lock(ctx context.Context, key string) error {
-	lockReq, err := json.Marshal(LockRequest{
-		Key:   key,
-		Token: r.Token,
-	})
+func (r *RestStorage) policy() retry.Policy {
+	p := retry.DefaultPolicy()
 
-	if err != nil {
-		return err
+	if r.LockInitialDelay > 0 {
+		p.InitialDelay = time.Duration(r.LockInitialDelay)
+	}
+
+	if r.LockMaxDelay > 0 {
+		p.MaxDelay = time.Duration(r.LockMaxDelay)
+	}
+
+	if r.LockTimeout > 0 {
+		p.Timeout = time.Duration(r.LockTimeout)
 	}
 
-	for {
-		req, err := http.NewRequestWithContext(ctx, "POST", r.Endpoint+"lock", bytes.NewBuffer(lockReq))
+	if r.LockJitter != nil {
+		p.Jitter = *r.LockJitter
+	}
+
+	return p
+}
+
+// buildClient constructs the *http.Client used for every RPC, applying
+// the transport tuning, proxy and TLS options set via the Caddyfile.
+// It's called once from Provision.
+func (r *RestStorage) buildClient() (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if r.ProxyURL != "" {
+		proxyURL, err := url.Parse(r.ProxyURL)
 
 		if err != nil {
-			return err
+			return nil, fmt.Errorf("rest storage: invalid proxy: %w", err)
 		}
 
-		req.Header.Add("Content-Type", "application/json")
-		resp, err := r.client.Do(req)
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if r.MaxIdleConns > 0 {
+		transport.MaxIdleConns = r.MaxIdleConns
+	}
+
+	if r.MaxConnsPerHost > 0 {
+		transport.MaxConnsPerHost = r.MaxConnsPerHost
+	}
+
+	if r.IdleConnTimeout > 0 {
+		transport.IdleConnTimeout = time.Duration(r.IdleConnTimeout)
+	}
+
+	if r.ResponseHeaderTimeout > 0 {
+		transport.ResponseHeaderTimeout = time.Duration(r.ResponseHeaderTimeout)
+	}
+
+	if r.HTTP2 != nil && !*r.HTTP2 {
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+
+	if r.TLS != nil {
+		tlsConfig, err := r.TLS.build()
 
 		if err != nil {
-			return err
+			return nil, err
 		}
 
-		resp.Body.Close()
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   time.Duration(r.Timeout),
+	}, nil
+}
+
+func (r *RestStorage) metricsEnabled() bool {
+	return r.Metrics != nil && *r.Metrics
+}
+
+func (r *RestStorage) tracingEnabled() bool {
+	return r.Tracing != nil && *r.Tracing
+}
+
+// newRequestID returns a fresh identifier for the request_id log
+// field. An error here is vanishingly rare (crypto/rand failure) and
+// not worth failing the RPC over, so it degrades to an empty field.
+func newRequestID() string {
+	id, err := retry.NewIdempotencyKey()
+
+	if err != nil {
+		return ""
+	}
+
+	return id
+}
+
+// statusLabel is the "status" value recorded in logs and metrics for
+// an RPC attempt: the final HTTP status code, or "error" when the
+// backend was never reached.
+func statusLabel(resp *http.Response) string {
+	if resp == nil {
+		return "error"
+	}
+
+	return strconv.Itoa(resp.StatusCode)
+}
+
+// logRPC emits the structured per-RPC log line described in the
+// package's observability design: one line per call to Lock, Unlock,
+// Store, Load, Delete, Exists, List or Stat.
+func (r *RestStorage) logRPC(verb, key, status string, attempts int, duration time.Duration, requestID string) {
+	if r.logger == nil {
+		return
+	}
+
+	r.logger.Info("rest storage rpc",
+		zap.String("verb", verb),
+		zap.String("key", key),
+		zap.String("status", status),
+		zap.Int("attempts", attempts),
+		zap.Int64("duration_ms", duration.Milliseconds()),
+		zap.String("request_id", requestID),
+	)
+}
+
+func (r *RestStorage) send(ctx context.Context, scheme auth.Scheme, method, url string, newBody func() io.Reader, contentType, idempotencyKey string) (*http.Response, error) {
+	var body io.Reader
+
+	if newBody != nil {
+		body = newBody()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if contentType != "" {
+		req.Header.Add("Content-Type", contentType)
+	}
+
+	if idempotencyKey != "" {
+		req.Header.Set("X-Idempotency-Key", idempotencyKey)
+	}
+
+	if err := scheme.Authorize(ctx, req); err != nil {
+		return nil, err
+	}
+
+	if r.tracingEnabled() {
+		tracing.Inject(ctx, req)
+	}
+
+	return r.client.Do(req)
+}
+
+func (r *RestStorage) request(ctx context.Context, scheme auth.Scheme, method, url string, newBody func() io.Reader, contentType, idempotencyKey string) (*http.Response, error) {
+	resp, err := r.send(ctx, scheme, method, url, newBody, contentType, idempotencyKey)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusUnauthorized {
+		return resp, nil
+	}
+
+	challenge, ok := auth.ParseChallenge(resp.Header.Get("WWW-Authenticate"))
+
+	if !ok {
+		return resp, nil
+	}
+
+	resp.Body.Close()
 
-		if resp.StatusCode == 201 {
-			return nil
+	if err := scheme.HandleChallenge(ctx, challenge); err != nil {
+		return nil, err
+	}
+
+	return r.send(ctx, scheme, method, url, newBody, contentType, idempotencyKey)
+}
+
+// responseClassifier decides, for a completed HTTP response within a
+// do() retry attempt, whether the RPC is finished and what error (if
+// any) it finished with. Returning done=false with a nil error retries
+// without treating the response as a failure (used by Lock's 412
+// contention case); returning a non-nil error closes the body and
+// hands it to retry.Do, which retries only if the error is Temporary.
+type responseClassifier func(resp *http.Response) (done bool, err error)
+
+// defaultClassifier treats 429 and 5xx as retryable failures and
+// any other status as a finished, successful attempt.
+func defaultClassifier(resp *http.Response) (bool, error) {
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return false, resterr.FromResponse(resp)
+	}
+
+	return true, nil
+}
+
+// do runs verb against url with the retry policy and the usual
+// metrics/tracing/logging bookkeeping, classifying each attempt's
+// response with classify (defaultClassifier if none is given).
+func (r *RestStorage) do(ctx context.Context, verb, key, method, url string, newBody func() io.Reader, contentType, idempotencyKey string, classify ...responseClassifier) (*http.Response, error) {
+	classifyFn := defaultClassifier
+
+	if len(classify) > 0 {
+		classifyFn = classify[0]
+	}
+
+	scheme, err := r.scheme()
+
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	requestID := newRequestID()
+
+	var span trace.Span
+
+	if r.tracingEnabled() {
+		ctx, span = tracing.StartSpan(ctx, verb, key, method, url)
+	}
+
+	var inFlightDone func()
+
+	if r.metricsEnabled() {
+		inFlightDone = metrics.Shared().InFlight(verb)
+	}
+
+	var resp *http.Response
+	attempts := 0
+
+	err = retry.Do(ctx, r.policy(), func(n int) (bool, error) {
+		attempts = n + 1
+
+		var attemptErr error
+
+		resp, attemptErr = r.request(ctx, scheme, method, url, newBody, contentType, idempotencyKey)
+
+		if attemptErr != nil {
+			return false, attemptErr
 		}
 
-		if resp.StatusCode != 412 {
-			return fmt.Errorf("Unknown status code received: {}", resp.StatusCode)
+		done, classErr := classifyFn(resp)
+
+		if !done {
+			resp.Body.Close()
+		}
+
+		return done, classErr
+	})
+
+	if inFlightDone != nil {
+		inFlightDone()
+	}
+
+	duration := time.Since(start)
+	status := statusLabel(resp)
+
+	if r.metricsEnabled() {
+		metrics.Shared().Observe(verb, status, duration)
+	}
+
+	if span != nil {
+		statusCode := 0
+
+		if resp != nil {
+			statusCode = resp.StatusCode
 		}
+
+		tracing.EndSpan(span, statusCode, err)
+	}
+
+	r.logRPC(verb, key, status, attempts, duration, requestID)
+
+	if err != nil {
+		return nil, err
 	}
+
+	return resp, nil
 }
 
-type UnlockRequest struct {
-	Key   string `json:"key"`
-	Token string `json:"token"`
+func jsonBody(v []byte) func() io.Reader {
+	return func() io.Reader {
+		return bytes.NewReader(v)
+	}
 }
 
-func (r *RestStorage) Unlock(ctx context.Context, key string) error {
-	unlockReq, err := json.Marshal(UnlockRequest{
-		Key:   key,
-		Token: r.Token,
+func keyURL(endpoint, key string) string {
+	return endpoint + "kv/" + url.PathEscape(key)
+}
+
+// ProbeResponse is returned by a GET to the storage root and advertises
+// the wire protocols the server understands, newest first.
+type ProbeResponse struct {
+	Versions []string `json:"versions"`
+}
+
+// resolveProtocol returns the wire format to speak: the configured
+// Protocol if one was set, otherwise the result of a one-time
+// negotiation probe against the server, cached for the life of r.
+// certmagic calls Store/Load/Delete/Exists/List/Stat concurrently, so
+// the probe runs at most once behind a sync.Once rather than a plain
+// nil check.
+func (r *RestStorage) resolveProtocol(ctx context.Context) (string, error) {
+	if r.Protocol != "" {
+		return r.Protocol, nil
+	}
+
+	r.protocolOnce.Do(func() {
+		scheme, err := r.scheme()
+
+		if err != nil {
+			r.protocolErr = err
+			return
+		}
+
+		r.negotiatedProtocol = r.probeProtocol(ctx, scheme)
+	})
+
+	return r.negotiatedProtocol, r.protocolErr
+}
+
+// probeProtocol issues a GET to the storage root and looks for
+// "binary/1" among the advertised versions. Any failure to reach the
+// server, a non-200 response, or an unparseable body is treated as
+// talking to a pre-negotiation server and falls back to JSON rather
+// than failing the call outright.
+func (r *RestStorage) probeProtocol(ctx context.Context, scheme auth.Scheme) string {
+	resp, err := r.request(ctx, scheme, "GET", r.Endpoint, nil, "", "")
+
+	if err != nil {
+		return protocolJSON
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return protocolJSON
+	}
+
+	var probeResp ProbeResponse
+
+	if err := json.NewDecoder(resp.Body).Decode(&probeResp); err != nil {
+		return protocolJSON
+	}
+
+	for _, v := range probeResp.Versions {
+		if v == "binary/1" {
+			return protocolBinary
+		}
+	}
+
+	return protocolJSON
+}
+
+type LockRequest struct {
+	Key string `json:"key"`
+}
+
+// lockClassifier finishes the attempt on 201 (lock acquired), retries
+// without error on 412 (another caller holds the lock), and treats any
+// other status as a failure, which retry.Do retries only if it is
+// Temporary (e.g. 429 or 5xx).
+func lockClassifier(resp *http.Response) (bool, error) {
+	switch resp.StatusCode {
+	case http.StatusCreated:
+		return true, nil
+	case http.StatusPreconditionFailed:
+		return false, nil
+	default:
+		return false, resterr.FromResponse(resp)
+	}
+}
+
+func (r *RestStorage) Lock(ctx context.Context, key string) error {
+	lockReq, err := json.Marshal(LockRequest{
+		Key: key,
 	})
 
 	if err != nil {
 		return err
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", r.Endpoint+"unlock", bytes.NewBuffer(unlockReq))
+	start := time.Now()
+
+	resp, err := r.do(ctx, "lock", key, "POST", r.Endpoint+"lock", jsonBody(lockReq), "application/json", "", lockClassifier)
+
+	if resp != nil {
+		resp.Body.Close()
+	}
+
+	if r.metricsEnabled() {
+		metrics.Shared().ObserveLockWait(time.Since(start))
+	}
+
+	return err
+}
+
+type UnlockRequest struct {
+	Key string `json:"key"`
+}
+
+func (r *RestStorage) Unlock(ctx context.Context, key string) error {
+	unlockReq, err := json.Marshal(UnlockRequest{
+		Key: key,
+	})
 
 	if err != nil {
 		return err
 	}
 
-	req.Header.Add("Content-Type", "application/json")
-	resp, err := r.client.Do(req)
+	resp, err := r.do(ctx, "unlock", key, "POST", r.Endpoint+"unlock", jsonBody(unlockReq), "application/json", "")
 
 	if err != nil {
 		return err
@@ -144,7 +899,7 @@ func (r *RestStorage) Unlock(ctx context.Context, key string) error {
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 204 {
-		return fmt.Errorf("Unknown status code received: {}", resp.StatusCode)
+		return resterr.FromResponse(resp)
 	}
 
 	return nil
@@ -153,30 +908,67 @@ func (r *RestStorage) Unlock(ctx context.Context, key string) error {
 type StoreRequest struct {
 	Key   string `json:"key"`
 	Value string `json:"value"`
-	Token string `json:"token"`
 }
 
 func (r *RestStorage) Store(ctx context.Context, key string, value []byte) error {
+	protocol, err := r.resolveProtocol(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	if protocol == protocolBinary {
+		return r.storeBinary(ctx, key, value)
+	}
+
+	return r.storeJSON(ctx, key, value)
+}
+
+func (r *RestStorage) storeJSON(ctx context.Context, key string, value []byte) error {
 	valueEnc := base64.StdEncoding.EncodeToString(value)
 
 	storeReq, err := json.Marshal(StoreRequest{
 		Key:   key,
 		Value: valueEnc,
-		Token: r.Token,
 	})
 
 	if err != nil {
 		return err
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", r.Endpoint+"store", bytes.NewBuffer(storeReq))
+	idempotencyKey, err := retry.NewIdempotencyKey()
+
+	if err != nil {
+		return err
+	}
+
+	resp, err := r.do(ctx, "store", key, "POST", r.Endpoint+"store", jsonBody(storeReq), "application/json", idempotencyKey)
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 201 {
+		return resterr.FromResponse(resp)
+	}
+
+	return nil
+}
+
+func (r *RestStorage) storeBinary(ctx context.Context, key string, value []byte) error {
+	idempotencyKey, err := retry.NewIdempotencyKey()
 
 	if err != nil {
 		return err
 	}
 
-	req.Header.Add("Content-Type", "application/json")
-	resp, err := r.client.Do(req)
+	newBody := func() io.Reader {
+		return bytes.NewReader(value)
+	}
+
+	resp, err := r.do(ctx, "store", key, "PUT", keyURL(r.Endpoint, key), newBody, "application/octet-stream", idempotencyKey)
 
 	if err != nil {
 		return err
@@ -185,15 +977,14 @@ func (r *RestStorage) Store(ctx context.Context, key string, value []byte) error
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 201 {
-		return fmt.Errorf("Unknown status code received: {}", resp.StatusCode)
+		return resterr.FromResponse(resp)
 	}
 
 	return nil
 }
 
 type LoadRequest struct {
-	Key   string `json:"key"`
-	Token string `json:"token"`
+	Key string `json:"key"`
 }
 
 type LoadResponse struct {
@@ -201,23 +992,29 @@ type LoadResponse struct {
 }
 
 func (r *RestStorage) Load(ctx context.Context, key string) ([]byte, error) {
-	loadReq, err := json.Marshal(LoadRequest{
-		Key:   key,
-		Token: r.Token,
-	})
+	protocol, err := r.resolveProtocol(ctx)
 
 	if err != nil {
 		return nil, err
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", r.Endpoint+"load", bytes.NewBuffer(loadReq))
+	if protocol == protocolBinary {
+		return r.loadBinary(ctx, key)
+	}
+
+	return r.loadJSON(ctx, key)
+}
+
+func (r *RestStorage) loadJSON(ctx context.Context, key string) ([]byte, error) {
+	loadReq, err := json.Marshal(LoadRequest{
+		Key: key,
+	})
 
 	if err != nil {
 		return nil, err
 	}
 
-	req.Header.Add("Content-Type", "application/json")
-	resp, err := r.client.Do(req)
+	resp, err := r.do(ctx, "load", key, "POST", r.Endpoint+"load", jsonBody(loadReq), "application/json", "")
 
 	if err != nil {
 		return nil, err
@@ -225,12 +1022,8 @@ func (r *RestStorage) Load(ctx context.Context, key string) ([]byte, error) {
 
 	defer resp.Body.Close()
 
-	if resp.StatusCode == 404 {
-		return nil, fs.ErrNotExist
-	}
-
 	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("Unknown status code received: {}", resp.StatusCode)
+		return nil, resterr.FromResponse(resp)
 	}
 
 	var loadResp LoadResponse
@@ -250,29 +1043,56 @@ func (r *RestStorage) Load(ctx context.Context, key string) ([]byte, error) {
 	return valueDec, nil
 }
 
+func (r *RestStorage) loadBinary(ctx context.Context, key string) ([]byte, error) {
+	resp, err := r.do(ctx, "load", key, "GET", keyURL(r.Endpoint, key), nil, "", "")
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, resterr.FromResponse(resp)
+	}
+
+	return io.ReadAll(resp.Body)
+}
+
 type DeleteRequest struct {
-	Key   string `json:"key"`
-	Token string `json:"token"`
+	Key string `json:"key"`
 }
 
 func (r *RestStorage) Delete(ctx context.Context, key string) error {
+	protocol, err := r.resolveProtocol(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	if protocol == protocolBinary {
+		return r.deleteBinary(ctx, key)
+	}
+
+	return r.deleteJSON(ctx, key)
+}
+
+func (r *RestStorage) deleteJSON(ctx context.Context, key string) error {
 	deleteReq, err := json.Marshal(DeleteRequest{
-		Key:   key,
-		Token: r.Token,
+		Key: key,
 	})
 
 	if err != nil {
 		return err
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", r.Endpoint+"delete", bytes.NewBuffer(deleteReq))
+	idempotencyKey, err := retry.NewIdempotencyKey()
 
 	if err != nil {
 		return err
 	}
 
-	req.Header.Add("Content-Type", "application/json")
-	resp, err := r.client.Do(req)
+	resp, err := r.do(ctx, "delete", key, "POST", r.Endpoint+"delete", jsonBody(deleteReq), "application/json", idempotencyKey)
 
 	if err != nil {
 		return err
@@ -280,20 +1100,37 @@ func (r *RestStorage) Delete(ctx context.Context, key string) error {
 
 	defer resp.Body.Close()
 
-	if resp.StatusCode == 404 {
-		return fs.ErrNotExist
+	if resp.StatusCode != 204 {
+		return resterr.FromResponse(resp)
+	}
+
+	return nil
+}
+
+func (r *RestStorage) deleteBinary(ctx context.Context, key string) error {
+	idempotencyKey, err := retry.NewIdempotencyKey()
+
+	if err != nil {
+		return err
+	}
+
+	resp, err := r.do(ctx, "delete", key, "DELETE", keyURL(r.Endpoint, key), nil, "", idempotencyKey)
+
+	if err != nil {
+		return err
 	}
 
+	defer resp.Body.Close()
+
 	if resp.StatusCode != 204 {
-		return fmt.Errorf("Unknown status code received: {}", resp.StatusCode)
+		return resterr.FromResponse(resp)
 	}
 
 	return nil
 }
 
 type ExistsRequest struct {
-	Key   string `json:"key"`
-	Token string `json:"token"`
+	Key string `json:"key"`
 }
 
 type ExistsResponse struct {
@@ -301,23 +1138,29 @@ type ExistsResponse struct {
 }
 
 func (r *RestStorage) Exists(ctx context.Context, key string) bool {
-	existsReq, err := json.Marshal(ExistsRequest{
-		Key:   key,
-		Token: r.Token,
-	})
+	protocol, err := r.resolveProtocol(ctx)
 
 	if err != nil {
 		return false
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", r.Endpoint+"exists", bytes.NewBuffer(existsReq))
+	if protocol == protocolBinary {
+		return r.existsBinary(ctx, key)
+	}
+
+	return r.existsJSON(ctx, key)
+}
+
+func (r *RestStorage) existsJSON(ctx context.Context, key string) bool {
+	existsReq, err := json.Marshal(ExistsRequest{
+		Key: key,
+	})
 
 	if err != nil {
 		return false
 	}
 
-	req.Header.Add("Content-Type", "application/json")
-	resp, err := r.client.Do(req)
+	resp, err := r.do(ctx, "exists", key, "POST", r.Endpoint+"exists", jsonBody(existsReq), "application/json", "")
 
 	if err != nil {
 		return false
@@ -340,10 +1183,21 @@ func (r *RestStorage) Exists(ctx context.Context, key string) bool {
 	return existsResp.Exists
 }
 
+func (r *RestStorage) existsBinary(ctx context.Context, key string) bool {
+	resp, err := r.do(ctx, "exists", key, "HEAD", keyURL(r.Endpoint, key), nil, "", "")
+
+	if err != nil {
+		return false
+	}
+
+	defer resp.Body.Close()
+
+	return resp.StatusCode == 200
+}
+
 type ListRequest struct {
 	Prefix    string `json:"prefix"`
 	Recursive bool   `json:"recursive"`
-	Token     string `json:"token"`
 }
 
 type ListResponse struct {
@@ -351,53 +1205,87 @@ type ListResponse struct {
 }
 
 func (r *RestStorage) List(ctx context.Context, prefix string, recursive bool) ([]string, error) {
+	protocol, err := r.resolveProtocol(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if protocol == protocolBinary {
+		return r.listBinary(ctx, prefix, recursive)
+	}
+
+	return r.listJSON(ctx, prefix, recursive)
+}
+
+func (r *RestStorage) listJSON(ctx context.Context, prefix string, recursive bool) ([]string, error) {
 	listReq, err := json.Marshal(ListRequest{
 		Prefix:    prefix,
 		Recursive: recursive,
-		Token:     r.Token,
 	})
 
 	if err != nil {
 		return nil, err
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", r.Endpoint+"list", bytes.NewBuffer(listReq))
+	resp, err := r.do(ctx, "list", prefix, "POST", r.Endpoint+"list", jsonBody(listReq), "application/json", "")
 
 	if err != nil {
 		return nil, err
 	}
 
-	req.Header.Add("Content-Type", "application/json")
-	resp, err := r.client.Do(req)
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, resterr.FromResponse(resp)
+	}
+
+	var listResp ListResponse
+
+	err = json.NewDecoder(resp.Body).Decode(&listResp)
 
 	if err != nil {
 		return nil, err
 	}
 
-	defer resp.Body.Close()
+	return listResp.Keys, nil
+}
 
-	if resp.StatusCode == 404 {
-		return nil, fs.ErrNotExist
+func (r *RestStorage) listBinary(ctx context.Context, prefix string, recursive bool) ([]string, error) {
+	query := url.Values{
+		"prefix":    {prefix},
+		"recursive": {strconv.FormatBool(recursive)},
 	}
 
-	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("Unknown status code received: {}", resp.StatusCode)
+	resp, err := r.do(ctx, "list", prefix, "GET", r.Endpoint+"kv/?"+query.Encode(), nil, "", "")
+
+	if err != nil {
+		return nil, err
 	}
 
-	var listResp ListResponse
+	defer resp.Body.Close()
 
-	err = json.NewDecoder(resp.Body).Decode(&listResp)
+	if resp.StatusCode != 200 {
+		return nil, resterr.FromResponse(resp)
+	}
+
+	body, err := io.ReadAll(resp.Body)
 
 	if err != nil {
 		return nil, err
 	}
 
-	return listResp.Keys, nil
+	body = bytes.TrimSpace(body)
+
+	if len(body) == 0 {
+		return nil, nil
+	}
+
+	return strings.Split(string(body), "\n"), nil
 }
 
 type StatRequest struct {
-	Key   string `json:"key"`
-	Token string `json:"token"`
+	Key string `json:"key"`
 }
 
 type StatResponse struct {
@@ -408,23 +1296,29 @@ type StatResponse struct {
 }
 
 func (r *RestStorage) Stat(ctx context.Context, key string) (certmagic.KeyInfo, error) {
-	statReq, err := json.Marshal(StatRequest{
-		Key:   key,
-		Token: r.Token,
-	})
+	protocol, err := r.resolveProtocol(ctx)
 
 	if err != nil {
 		return certmagic.KeyInfo{}, err
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", r.Endpoint+"stat", bytes.NewBuffer(statReq))
+	if protocol == protocolBinary {
+		return r.statBinary(ctx, key)
+	}
+
+	return r.statJSON(ctx, key)
+}
+
+func (r *RestStorage) statJSON(ctx context.Context, key string) (certmagic.KeyInfo, error) {
+	statReq, err := json.Marshal(StatRequest{
+		Key: key,
+	})
 
 	if err != nil {
 		return certmagic.KeyInfo{}, err
 	}
 
-	req.Header.Add("Content-Type", "application/json")
-	resp, err := r.client.Do(req)
+	resp, err := r.do(ctx, "stat", key, "POST", r.Endpoint+"stat", jsonBody(statReq), "application/json", "")
 
 	if err != nil {
 		return certmagic.KeyInfo{}, err
@@ -432,12 +1326,8 @@ func (r *RestStorage) Stat(ctx context.Context, key string) (certmagic.KeyInfo,
 
 	defer resp.Body.Close()
 
-	if resp.StatusCode == 404 {
-		return certmagic.KeyInfo{}, fs.ErrNotExist
-	}
-
 	if resp.StatusCode != 200 {
-		return certmagic.KeyInfo{}, fmt.Errorf("Unknown status code received: {}", resp.StatusCode)
+		return certmagic.KeyInfo{}, resterr.FromResponse(resp)
 	}
 
 	var statResp StatResponse
@@ -461,3 +1351,42 @@ func (r *RestStorage) Stat(ctx context.Context, key string) (certmagic.KeyInfo,
 		IsTerminal: statResp.IsTerminal,
 	}, nil
 }
+
+func (r *RestStorage) statBinary(ctx context.Context, key string) (certmagic.KeyInfo, error) {
+	resp, err := r.do(ctx, "stat", key, "HEAD", keyURL(r.Endpoint, key), nil, "", "")
+
+	if err != nil {
+		return certmagic.KeyInfo{}, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return certmagic.KeyInfo{}, resterr.FromResponse(resp)
+	}
+
+	size, err := strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
+
+	if err != nil {
+		return certmagic.KeyInfo{}, err
+	}
+
+	modified, err := http.ParseTime(resp.Header.Get("Last-Modified"))
+
+	if err != nil {
+		return certmagic.KeyInfo{}, err
+	}
+
+	isTerminal, err := strconv.ParseBool(resp.Header.Get("X-Terminal"))
+
+	if err != nil {
+		return certmagic.KeyInfo{}, err
+	}
+
+	return certmagic.KeyInfo{
+		Key:        key,
+		Modified:   modified,
+		Size:       size,
+		IsTerminal: isTerminal,
+	}, nil
+}
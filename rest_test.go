@@ -0,0 +1,254 @@
+package rest
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestStorage(endpoint string) *RestStorage {
+	return &RestStorage{
+		Endpoint:   endpoint,
+		AuthScheme: "bearer",
+		AuthArgs:   []string{"test-token"},
+		client:     &http.Client{},
+	}
+}
+
+func TestStoreLoadDeleteBinary(t *testing.T) {
+	store := map[string][]byte{}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/" {
+			json.NewEncoder(w).Encode(ProbeResponse{Versions: []string{"json/1", "binary/1"}})
+			return
+		}
+
+		key := req.URL.Path[len("/kv/"):]
+
+		switch req.Method {
+		case http.MethodPut:
+			body, err := io.ReadAll(req.Body)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			store[key] = body
+			w.WriteHeader(http.StatusCreated)
+		case http.MethodGet:
+			value, ok := store[key]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write(value)
+		case http.MethodDelete:
+			delete(store, key)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	defer srv.Close()
+
+	r := newTestStorage(srv.URL + "/")
+	ctx := context.Background()
+
+	if err := r.Store(ctx, "cert1", []byte("hello binary")); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+
+	value, err := r.Load(ctx, "cert1")
+
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	if string(value) != "hello binary" {
+		t.Fatalf("Load returned %q, want %q", value, "hello binary")
+	}
+
+	if err := r.Delete(ctx, "cert1"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+
+	if _, err := r.Load(ctx, "cert1"); err == nil {
+		t.Fatal("Load after Delete: expected error, got nil")
+	}
+}
+
+func TestResolveProtocolFallsBackToJSON(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	r := newTestStorage(srv.URL + "/")
+
+	protocol, err := r.resolveProtocol(context.Background())
+
+	if err != nil {
+		t.Fatalf("resolveProtocol: %v", err)
+	}
+
+	if protocol != protocolJSON {
+		t.Fatalf("protocol = %q, want %q", protocol, protocolJSON)
+	}
+}
+
+func TestResolveProtocolHonorsConfiguredValue(t *testing.T) {
+	r := newTestStorage("http://unused/")
+	r.Protocol = protocolBinary
+
+	protocol, err := r.resolveProtocol(context.Background())
+
+	if err != nil {
+		t.Fatalf("resolveProtocol: %v", err)
+	}
+
+	if protocol != protocolBinary {
+		t.Fatalf("protocol = %q, want %q", protocol, protocolBinary)
+	}
+}
+
+func TestListBinary(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/" {
+			json.NewEncoder(w).Encode(ProbeResponse{Versions: []string{"binary/1"}})
+			return
+		}
+
+		if req.URL.Path == "/kv/" {
+			w.Write([]byte("a/cert1\na/cert2\n"))
+			return
+		}
+
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	r := newTestStorage(srv.URL + "/")
+
+	keys, err := r.List(context.Background(), "a/", true)
+
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+
+	if len(keys) != 2 || keys[0] != "a/cert1" || keys[1] != "a/cert2" {
+		t.Fatalf("List = %v, want [a/cert1 a/cert2]", keys)
+	}
+}
+
+// generateSelfSignedCert returns a freshly minted self-signed
+// certificate and key, PEM-encoded, for use as a test client
+// certificate.
+func generateSelfSignedCert(t *testing.T) (certPEM, keyPEM []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "rest storage test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate: %v", err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalECPrivateKey: %v", err)
+	}
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	return certPEM, keyPEM
+}
+
+func writeTemp(t *testing.T, name string, data []byte) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("writing %s: %v", path, err)
+	}
+
+	return path
+}
+
+func TestBuildClientMutualTLS(t *testing.T) {
+	var sawClientCert bool
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		sawClientCert = len(req.TLS.PeerCertificates) > 0
+		w.WriteHeader(http.StatusOK)
+	}))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	srv.StartTLS()
+	defer srv.Close()
+
+	clientCertPEM, clientKeyPEM := generateSelfSignedCert(t)
+
+	caPath := writeTemp(t, "ca.pem", pemEncodeServerCert(t, srv))
+	certPath := writeTemp(t, "client.pem", clientCertPEM)
+	keyPath := writeTemp(t, "client-key.pem", clientKeyPEM)
+
+	r := &RestStorage{
+		TLS: &TLSConfig{
+			CA:   caPath,
+			Cert: certPath,
+			Key:  keyPath,
+		},
+	}
+
+	client, err := r.buildClient()
+	if err != nil {
+		t.Fatalf("buildClient: %v", err)
+	}
+
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want 200", resp.StatusCode)
+	}
+
+	if !sawClientCert {
+		t.Fatal("server did not see a client certificate")
+	}
+}
+
+func pemEncodeServerCert(t *testing.T, srv *httptest.Server) []byte {
+	t.Helper()
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+}
@@ -0,0 +1,148 @@
+package resterr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	CodeInvalidRequest = "invalid_request"
+	CodeUnauthorized   = "unauthorized"
+	CodeForbidden      = "forbidden"
+	CodeNotFound       = "not_found"
+	CodeConflict       = "conflict"
+	CodeLocked         = "locked"
+	CodeRateLimited    = "rate_limited"
+	CodeServerError    = "server_error"
+	CodeUnavailable    = "unavailable"
+)
+
+var (
+	ErrLockExists  = errors.New("rest storage: lock already exists")
+	ErrRateLimited = errors.New("rest storage: rate limited")
+)
+
+// RestError is the error envelope returned by the REST backend for any
+// non-2xx response, modeled on the OAuth2/IndieAuth error shape:
+// {"error":"code","error_description":"...","error_uri":"..."}.
+type RestError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+	URI         string `json:"error_uri,omitempty"`
+
+	StatusCode int
+
+	retryAfter time.Duration
+}
+
+func (e *RestError) Error() string {
+	if e.Description != "" {
+		return fmt.Sprintf("rest storage: %s: %s", e.Code, e.Description)
+	}
+
+	return fmt.Sprintf("rest storage: %s", e.Code)
+}
+
+func (e *RestError) Unwrap() error {
+	switch e.Code {
+	case CodeNotFound:
+		return fs.ErrNotExist
+	case CodeLocked:
+		return ErrLockExists
+	case CodeRateLimited:
+		return ErrRateLimited
+	default:
+		return nil
+	}
+}
+
+// Temporary reports whether the request that produced this error is
+// safe to retry.
+func (e *RestError) Temporary() bool {
+	switch e.Code {
+	case CodeRateLimited, CodeServerError, CodeUnavailable:
+		return true
+	default:
+		return false
+	}
+}
+
+// RetryAfter returns how long to wait before retrying, as conveyed by
+// the response's Retry-After header, or zero if none was given.
+func (e *RestError) RetryAfter() time.Duration {
+	return e.retryAfter
+}
+
+// FromResponse builds an error from a non-2xx response. If the body
+// carries a JSON error envelope it is decoded into a RestError;
+// otherwise a RestError is synthesized from the status code alone so
+// callers can still rely on Unwrap, Temporary and RetryAfter.
+func FromResponse(resp *http.Response) error {
+	retryAfter := parseRetryAfter(resp.Header.Get("Retry-After"))
+
+	if mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err == nil && mediaType == "application/json" {
+		var restErr RestError
+
+		if err := json.NewDecoder(resp.Body).Decode(&restErr); err == nil && restErr.Code != "" {
+			restErr.StatusCode = resp.StatusCode
+			restErr.retryAfter = retryAfter
+
+			return &restErr
+		}
+	}
+
+	return &RestError{
+		Code:       codeForStatus(resp.StatusCode),
+		StatusCode: resp.StatusCode,
+		retryAfter: retryAfter,
+	}
+}
+
+func codeForStatus(status int) string {
+	switch status {
+	case http.StatusUnauthorized:
+		return CodeUnauthorized
+	case http.StatusForbidden:
+		return CodeForbidden
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusConflict:
+		return CodeConflict
+	case http.StatusLocked, http.StatusPreconditionFailed:
+		return CodeLocked
+	case http.StatusTooManyRequests:
+		return CodeRateLimited
+	case http.StatusServiceUnavailable:
+		return CodeUnavailable
+	default:
+		if status >= 500 {
+			return CodeServerError
+		}
+
+		return CodeInvalidRequest
+	}
+}
+
+func parseRetryAfter(header string) time.Duration {
+	if header == "" {
+		return 0
+	}
+
+	if secs, err := strconv.Atoi(header); err == nil {
+		return time.Duration(secs) * time.Second
+	}
+
+	if t, err := http.ParseTime(header); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,182 @@
+package resterr
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func jsonResponse(status int, body string) *http.Response {
+	return &http.Response{
+		StatusCode: status,
+		Header: http.Header{
+			"Content-Type": []string{"application/json"},
+		},
+		Body: io.NopCloser(bytes.NewBufferString(body)),
+	}
+}
+
+func plainResponse(status int, header http.Header) *http.Response {
+	if header == nil {
+		header = http.Header{}
+	}
+
+	return &http.Response{
+		StatusCode: status,
+		Header:     header,
+		Body:       io.NopCloser(bytes.NewReader(nil)),
+	}
+}
+
+func TestFromResponseDecodesJSONEnvelope(t *testing.T) {
+	resp := jsonResponse(http.StatusNotFound, `{"error":"not_found","error_description":"no such key"}`)
+
+	err := FromResponse(resp)
+
+	var restErr *RestError
+
+	if !errors.As(err, &restErr) {
+		t.Fatalf("FromResponse returned %T, want *RestError", err)
+	}
+
+	if restErr.Code != CodeNotFound || restErr.Description != "no such key" {
+		t.Fatalf("restErr = %+v, want code %q with description", restErr, CodeNotFound)
+	}
+
+	if restErr.StatusCode != http.StatusNotFound {
+		t.Fatalf("StatusCode = %d, want %d", restErr.StatusCode, http.StatusNotFound)
+	}
+}
+
+func TestFromResponseSynthesizesFromStatusWithoutBody(t *testing.T) {
+	resp := plainResponse(http.StatusConflict, nil)
+
+	err := FromResponse(resp)
+
+	var restErr *RestError
+
+	if !errors.As(err, &restErr) {
+		t.Fatalf("FromResponse returned %T, want *RestError", err)
+	}
+
+	if restErr.Code != CodeConflict {
+		t.Fatalf("Code = %q, want %q", restErr.Code, CodeConflict)
+	}
+}
+
+func TestCodeForStatusMapping(t *testing.T) {
+	cases := map[int]string{
+		http.StatusUnauthorized:        CodeUnauthorized,
+		http.StatusForbidden:           CodeForbidden,
+		http.StatusNotFound:            CodeNotFound,
+		http.StatusConflict:            CodeConflict,
+		http.StatusLocked:              CodeLocked,
+		http.StatusPreconditionFailed:  CodeLocked,
+		http.StatusTooManyRequests:     CodeRateLimited,
+		http.StatusServiceUnavailable:  CodeUnavailable,
+		http.StatusInternalServerError: CodeServerError,
+		http.StatusBadRequest:          CodeInvalidRequest,
+	}
+
+	for status, want := range cases {
+		if got := codeForStatus(status); got != want {
+			t.Errorf("codeForStatus(%d) = %q, want %q", status, got, want)
+		}
+	}
+}
+
+func TestUnwrapMapsToSentinels(t *testing.T) {
+	cases := []struct {
+		code string
+		want error
+	}{
+		{CodeNotFound, fs.ErrNotExist},
+		{CodeLocked, ErrLockExists},
+		{CodeRateLimited, ErrRateLimited},
+	}
+
+	for _, c := range cases {
+		err := error(&RestError{Code: c.code})
+
+		if !errors.Is(err, c.want) {
+			t.Errorf("errors.Is(%q error, %v) = false, want true", c.code, c.want)
+		}
+	}
+
+	if unwrapped := (&RestError{Code: CodeForbidden}).Unwrap(); unwrapped != nil {
+		t.Errorf("Unwrap() for %q = %v, want nil", CodeForbidden, unwrapped)
+	}
+}
+
+func TestTemporary(t *testing.T) {
+	temporary := []string{CodeRateLimited, CodeServerError, CodeUnavailable}
+	permanent := []string{CodeInvalidRequest, CodeUnauthorized, CodeForbidden, CodeNotFound, CodeConflict, CodeLocked}
+
+	for _, code := range temporary {
+		if !(&RestError{Code: code}).Temporary() {
+			t.Errorf("Temporary() for %q = false, want true", code)
+		}
+	}
+
+	for _, code := range permanent {
+		if (&RestError{Code: code}).Temporary() {
+			t.Errorf("Temporary() for %q = true, want false", code)
+		}
+	}
+}
+
+func TestParseRetryAfterSeconds(t *testing.T) {
+	resp := plainResponse(http.StatusTooManyRequests, http.Header{"Retry-After": []string{"30"}})
+
+	err := FromResponse(resp)
+
+	var restErr *RestError
+
+	if !errors.As(err, &restErr) {
+		t.Fatalf("FromResponse returned %T, want *RestError", err)
+	}
+
+	if restErr.RetryAfter() != 30*time.Second {
+		t.Fatalf("RetryAfter() = %v, want 30s", restErr.RetryAfter())
+	}
+}
+
+func TestParseRetryAfterHTTPDate(t *testing.T) {
+	future := time.Now().Add(1 * time.Minute)
+	resp := plainResponse(http.StatusServiceUnavailable, http.Header{"Retry-After": []string{future.UTC().Format(http.TimeFormat)}})
+
+	err := FromResponse(resp)
+
+	var restErr *RestError
+
+	if !errors.As(err, &restErr) {
+		t.Fatalf("FromResponse returned %T, want *RestError", err)
+	}
+
+	got := restErr.RetryAfter()
+
+	if got <= 0 || got > 1*time.Minute {
+		t.Fatalf("RetryAfter() = %v, want in (0, 1m]", got)
+	}
+}
+
+func TestParseRetryAfterPastDateYieldsZero(t *testing.T) {
+	past := time.Now().Add(-1 * time.Minute)
+	resp := plainResponse(http.StatusServiceUnavailable, http.Header{"Retry-After": []string{past.UTC().Format(http.TimeFormat)}})
+
+	err := FromResponse(resp)
+
+	var restErr *RestError
+
+	if !errors.As(err, &restErr) {
+		t.Fatalf("FromResponse returned %T, want *RestError", err)
+	}
+
+	if restErr.RetryAfter() != 0 {
+		t.Fatalf("RetryAfter() = %v, want 0", restErr.RetryAfter())
+	}
+}
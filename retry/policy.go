@@ -0,0 +1,113 @@
+package retry
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Policy is a full-jitter exponential backoff policy, as described in
+// the AWS "Exponential Backoff and Jitter" algorithm:
+// sleep = rand(0, min(cap, base*2^attempt)).
+type Policy struct {
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+	Multiplier   float64
+	Jitter       bool
+	Timeout      time.Duration
+}
+
+func DefaultPolicy() Policy {
+	return Policy{
+		InitialDelay: 250 * time.Millisecond,
+		MaxDelay:     10 * time.Second,
+		Multiplier:   2.0,
+		Jitter:       true,
+		Timeout:      5 * time.Minute,
+	}
+}
+
+func (p Policy) delay(attempt int) time.Duration {
+	backoff := float64(p.InitialDelay) * math.Pow(p.Multiplier, float64(attempt))
+
+	if backoff > float64(p.MaxDelay) {
+		backoff = float64(p.MaxDelay)
+	}
+
+	if !p.Jitter {
+		return time.Duration(backoff)
+	}
+
+	return time.Duration(rand.Int63n(int64(backoff) + 1))
+}
+
+// Do calls attempt repeatedly until it reports done, returns a
+// non-temporary error, ctx is cancelled, or the policy's wall-clock
+// timeout elapses. An error satisfying the Temporary() bool interface
+// is retried rather than returned immediately; if it also satisfies
+// RetryAfter() time.Duration, that duration overrides the computed
+// backoff delay for the next attempt. Between attempts it sleeps,
+// honoring ctx cancellation immediately rather than waiting for the
+// next attempt.
+func Do(ctx context.Context, p Policy, attempt func(n int) (done bool, err error)) error {
+	deadline := time.Now().Add(p.Timeout)
+
+	for n := 0; ; n++ {
+		done, err := attempt(n)
+
+		if err != nil && !temporary(err) {
+			return err
+		}
+
+		if err == nil && done {
+			return nil
+		}
+
+		if p.Timeout > 0 && time.Now().After(deadline) {
+			if err != nil {
+				return err
+			}
+
+			return context.DeadlineExceeded
+		}
+
+		d := p.delay(n)
+
+		if ra := retryAfter(err); ra > 0 {
+			d = ra
+		}
+
+		select {
+		case <-time.After(d):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+type temporaryError interface {
+	Temporary() bool
+}
+
+func temporary(err error) bool {
+	t, ok := err.(temporaryError)
+
+	return ok && t.Temporary()
+}
+
+type retryAfterError interface {
+	RetryAfter() time.Duration
+}
+
+func retryAfter(err error) time.Duration {
+	if err == nil {
+		return 0
+	}
+
+	if ra, ok := err.(retryAfterError); ok {
+		return ra.RetryAfter()
+	}
+
+	return 0
+}
@@ -0,0 +1,166 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPolicyDelayGrowsAndCaps(t *testing.T) {
+	p := Policy{
+		InitialDelay: 100 * time.Millisecond,
+		MaxDelay:     1 * time.Second,
+		Multiplier:   2.0,
+		Jitter:       false,
+	}
+
+	want := []time.Duration{
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		1 * time.Second,
+		1 * time.Second,
+	}
+
+	for n, w := range want {
+		if got := p.delay(n); got != w {
+			t.Fatalf("delay(%d) = %v, want %v", n, got, w)
+		}
+	}
+}
+
+func TestPolicyDelayJitterStaysInBounds(t *testing.T) {
+	p := Policy{
+		InitialDelay: 100 * time.Millisecond,
+		MaxDelay:     1 * time.Second,
+		Multiplier:   2.0,
+		Jitter:       true,
+	}
+
+	bound := 400 * time.Millisecond
+
+	for i := 0; i < 100; i++ {
+		d := p.delay(2)
+
+		if d < 0 || d > bound {
+			t.Fatalf("delay(2) = %v, want in [0, %v]", d, bound)
+		}
+	}
+}
+
+type tempError struct {
+	retryAfter time.Duration
+}
+
+func (e *tempError) Error() string { return "temp error" }
+
+func (e *tempError) Temporary() bool { return true }
+
+func (e *tempError) RetryAfter() time.Duration { return e.retryAfter }
+
+var errPermanent = errors.New("permanent error")
+
+func TestDoRetriesTemporaryErrorUntilSuccess(t *testing.T) {
+	attempts := 0
+
+	err := Do(context.Background(), Policy{InitialDelay: time.Millisecond, MaxDelay: time.Millisecond, Multiplier: 2.0}, func(n int) (bool, error) {
+		attempts++
+
+		if attempts < 3 {
+			return false, &tempError{}
+		}
+
+		return true, nil
+	})
+
+	if err != nil {
+		t.Fatalf("Do: %v", err)
+	}
+
+	if attempts != 3 {
+		t.Fatalf("attempts = %d, want 3", attempts)
+	}
+}
+
+func TestDoReturnsPermanentErrorImmediately(t *testing.T) {
+	attempts := 0
+
+	err := Do(context.Background(), Policy{InitialDelay: time.Millisecond, MaxDelay: time.Millisecond}, func(n int) (bool, error) {
+		attempts++
+		return false, errPermanent
+	})
+
+	if !errors.Is(err, errPermanent) {
+		t.Fatalf("err = %v, want %v", err, errPermanent)
+	}
+
+	if attempts != 1 {
+		t.Fatalf("attempts = %d, want 1", attempts)
+	}
+}
+
+func TestDoHonorsContextCancellationDuringSleep(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	attempts := 0
+
+	err := Do(ctx, Policy{InitialDelay: time.Hour, MaxDelay: time.Hour}, func(n int) (bool, error) {
+		attempts++
+		return false, &tempError{}
+	})
+
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want %v", err, context.Canceled)
+	}
+
+	if attempts != 1 {
+		t.Fatalf("attempts = %d, want 1", attempts)
+	}
+}
+
+func TestDoRetryAfterOverridesComputedDelay(t *testing.T) {
+	attempts := 0
+	var elapsed time.Duration
+
+	start := time.Now()
+
+	err := Do(context.Background(), Policy{InitialDelay: time.Hour, MaxDelay: time.Hour}, func(n int) (bool, error) {
+		attempts++
+
+		if attempts < 2 {
+			return false, &tempError{retryAfter: 10 * time.Millisecond}
+		}
+
+		elapsed = time.Since(start)
+
+		return true, nil
+	})
+
+	if err != nil {
+		t.Fatalf("Do: %v", err)
+	}
+
+	if elapsed > 500*time.Millisecond {
+		t.Fatalf("elapsed = %v, want RetryAfter (10ms) to override the hour-long computed delay", elapsed)
+	}
+}
+
+func TestDoStopsAtTimeout(t *testing.T) {
+	attempts := 0
+
+	err := Do(context.Background(), Policy{InitialDelay: time.Millisecond, MaxDelay: time.Millisecond, Timeout: 5 * time.Millisecond}, func(n int) (bool, error) {
+		attempts++
+		return false, &tempError{}
+	})
+
+	if err == nil {
+		t.Fatalf("Do: expected an error once the timeout elapses, got nil")
+	}
+
+	if attempts < 1 {
+		t.Fatalf("attempts = %d, want at least 1", attempts)
+	}
+}
@@ -0,0 +1,54 @@
+// Package tracing wraps the OpenTelemetry calls needed to instrument
+// REST storage RPCs as HTTP client spans and to propagate trace
+// context onto outbound requests.
+package tracing
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/fluidnode/caddy_rest_storage")
+
+// StartSpan begins a client-kind span for a single REST storage RPC,
+// tagged with the HTTP client semantic convention attributes plus
+// rest_storage.verb and rest_storage.key.
+func StartSpan(ctx context.Context, verb, key, method, url string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "rest_storage."+verb,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.method", method),
+			attribute.String("http.url", url),
+			attribute.String("rest_storage.verb", verb),
+			attribute.String("rest_storage.key", key),
+		),
+	)
+}
+
+// EndSpan records the final HTTP status code (if any) and error onto
+// span, then ends it.
+func EndSpan(span trace.Span, statusCode int, err error) {
+	if statusCode != 0 {
+		span.SetAttributes(attribute.Int("http.status_code", statusCode))
+	}
+
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
+
+// Inject propagates the span context carried by ctx onto req's headers
+// (traceparent/tracestate) so the REST backend can correlate its own
+// spans with the caller's.
+func Inject(ctx context.Context, req *http.Request) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+}